@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"math/rand"
+	"time"
+)
+
+// jitterBackoff produces a jittered exponential backoff, used as the
+// fallback poll interval when a watch cannot be established (e.g. against
+// a hub-agent cluster with restricted RBAC).
+type jitterBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	factor  float64
+	jitter  bool
+
+	current time.Duration
+}
+
+func newJitterBackoff(initial, max time.Duration, factor float64, jitter bool) *jitterBackoff {
+	return &jitterBackoff{
+		initial: initial,
+		max:     max,
+		factor:  factor,
+		jitter:  jitter,
+		current: initial,
+	}
+}
+
+// Next returns the next wait duration and advances the backoff.
+func (b *jitterBackoff) Next() time.Duration {
+	d := b.current
+	next := time.Duration(float64(b.current) * b.factor)
+	if next > b.max {
+		next = b.max
+	}
+	b.current = next
+
+	if b.jitter {
+		d = time.Duration(float64(d) * (0.5 + rand.Float64()*0.5))
+	}
+	return d
+}
+
+// Reset restores the backoff to its initial interval, used whenever a
+// readiness transition is observed.
+func (b *jitterBackoff) Reset() {
+	b.current = b.initial
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	crClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+)
+
+// hook delete policies, analogous to Helm's hook-delete-policy annotation.
+const (
+	HookDeletePolicyBeforeCreation = "before-hook-creation"
+	HookDeletePolicySucceeded      = "hook-succeeded"
+	HookDeletePolicyFailed         = "hook-failed"
+)
+
+const defaultHookPollInterval = 2 * time.Second
+
+// hookOutcome records whether a created hook ultimately succeeded, so
+// cleanupHooks can honor HookDeletePolicySucceeded/HookDeletePolicyFailed
+// instead of deleting every created hook regardless of how it finished.
+type hookOutcome struct {
+	hook      *commonmodels.DeployHook
+	succeeded bool
+}
+
+// runHooks creates each hook manifest in weight order, waits for it to
+// complete, and cleans it up according to its delete policy. It returns on
+// the first hook failure so that callers can fail the deploy job.
+func runHooks(ctx context.Context, hooks []*commonmodels.DeployHook, namespace string, kubeClient crClient.Client, logger *zap.SugaredLogger) error {
+	sorted := make([]*commonmodels.DeployHook, len(hooks))
+	copy(sorted, hooks)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Weight < sorted[j].Weight })
+
+	var outcomes []hookOutcome
+	defer func() { cleanupHooks(outcomes, namespace, kubeClient, logger) }()
+
+	checker := NewReadyChecker(namespace, kubeClient, "", logger)
+	for _, hook := range sorted {
+		if hook.DeletePolicy == HookDeletePolicyBeforeCreation {
+			if err := deleteHookResource(hook, namespace, kubeClient); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete previous hook %s/%s before re-creation: %v", namespace, hook.Name, err)
+			}
+		}
+
+		if err := kubeClient.Create(ctx, hook.Object); err != nil {
+			return fmt.Errorf("failed to create hook %s/%s: %v", namespace, hook.Name, err)
+		}
+
+		if err := waitHookComplete(ctx, hook, namespace, kubeClient, logger, checker); err != nil {
+			outcomes = append(outcomes, hookOutcome{hook: hook, succeeded: false})
+			return fmt.Errorf("hook %s/%s failed: %v", namespace, hook.Name, err)
+		}
+		outcomes = append(outcomes, hookOutcome{hook: hook, succeeded: true})
+	}
+	return nil
+}
+
+// waitHookComplete polls a Job hook's completion through the shared
+// ReadyChecker (making its Job branch genuinely reachable, rather than
+// duplicating that logic here) and a Pod hook's phase directly, treating
+// Succeeded as complete, since the checker reports a running Pod as ready.
+// It separately watches for a terminal failure, since ReadyChecker.IsReady
+// only distinguishes ready from not-yet-ready and a failed Job/Pod will
+// never become ready.
+func waitHookComplete(ctx context.Context, hook *commonmodels.DeployHook, namespace string, kubeClient crClient.Client, logger *zap.SugaredLogger, checker *ReadyChecker) error {
+	resource := commonmodels.Resource{Name: hook.Name}
+	switch hook.Object.(type) {
+	case *batchv1.Job:
+		resource.Kind = setting.Job
+	case *corev1.Pod:
+		resource.Kind = setting.Pod
+	}
+
+	if hook.Timeout == 0 {
+		hook.Timeout = setting.DeployTimeout
+	}
+	timeout := time.After(time.Duration(hook.Timeout) * time.Second)
+	ticker := time.NewTicker(defaultHookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled while waiting for hook")
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for hook to complete")
+		case <-ticker.C:
+			if resource.Kind != setting.Pod {
+				ready, err := checker.IsReady(resource)
+				if err != nil {
+					logger.Errorf("failed to check hook readiness %s/%s: %v", namespace, hook.Name, err)
+					continue
+				}
+				if ready {
+					return nil
+				}
+			}
+
+			switch res := hook.Object.(type) {
+			case *batchv1.Job:
+				job := &batchv1.Job{}
+				if err := kubeClient.Get(ctx, crClient.ObjectKeyFromObject(res), job); err != nil {
+					continue
+				}
+				for _, cond := range job.Status.Conditions {
+					if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+						return fmt.Errorf("hook job failed: %s", cond.Message)
+					}
+				}
+			case *corev1.Pod:
+				pod := &corev1.Pod{}
+				if err := kubeClient.Get(ctx, crClient.ObjectKeyFromObject(res), pod); err != nil {
+					continue
+				}
+				if pod.Status.Phase == corev1.PodSucceeded {
+					return nil
+				}
+				if pod.Status.Phase == corev1.PodFailed {
+					return fmt.Errorf("hook pod failed: %s", pod.Status.Message)
+				}
+			}
+		}
+	}
+}
+
+// cleanupHooks runs the delete-policy cleanup pass. It always runs in a
+// deferred call so that a failed hook still leaves the namespace clean for
+// the next workflow run. Each hook is only deleted if its outcome matches
+// its delete policy: HookDeletePolicySucceeded only deletes hooks that
+// completed successfully, HookDeletePolicyFailed only deletes ones that
+// didn't, so a hook kept around for post-mortem debugging isn't deleted
+// because some other hook happened to run to completion.
+func cleanupHooks(outcomes []hookOutcome, namespace string, kubeClient crClient.Client, logger *zap.SugaredLogger) {
+	for _, outcome := range outcomes {
+		switch outcome.hook.DeletePolicy {
+		case HookDeletePolicySucceeded:
+			if !outcome.succeeded {
+				continue
+			}
+		case HookDeletePolicyFailed:
+			if outcome.succeeded {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := deleteHookResource(outcome.hook, namespace, kubeClient); err != nil && !apierrors.IsNotFound(err) {
+			logger.Errorf("failed to clean up hook %s/%s: %v", namespace, outcome.hook.Name, err)
+		}
+	}
+}
+
+func deleteHookResource(hook *commonmodels.DeployHook, namespace string, kubeClient crClient.Client) error {
+	ctx := context.Background()
+	switch res := hook.Object.(type) {
+	case *batchv1.Job:
+		propagation := crClient.PropagationPolicy("Background")
+		return kubeClient.Delete(ctx, res, propagation)
+	default:
+		return kubeClient.Delete(ctx, hook.Object.(crClient.Object))
+	}
+}
@@ -49,6 +49,17 @@ type DeployJobCtl struct {
 	restConfig  *rest.Config
 	jobTaskSpec *commonmodels.JobTaskDeploySpec
 	ack         func()
+
+	// clusterClients/clusterRestConfigs are populated by runMultiCluster and
+	// keyed by ClusterID when JobTaskDeploySpec.ClusterIDs fans the deploy
+	// out across more than one cluster.
+	clusterClients     map[string]crClient.Client
+	clusterRestConfigs map[string]*rest.Config
+
+	// blueGreenCleanups holds the retired "blue" Deployments from a
+	// BlueGreen rollout, deleted by finalizeBlueGreen once the job's final
+	// status (including any rollback) is known and only if the job passed.
+	blueGreenCleanups []*blueGreenCleanup
 }
 
 func NewDeployJobCtl(job *commonmodels.JobTask, workflowCtx *commonmodels.WorkflowTaskCtx, ack func(), logger *zap.SugaredLogger) *DeployJobCtl {
@@ -66,6 +77,16 @@ func NewDeployJobCtl(job *commonmodels.JobTask, workflowCtx *commonmodels.Workfl
 }
 
 func (c *DeployJobCtl) Run(ctx context.Context) {
+	if err := c.prepareClient(); err != nil {
+		return
+	}
+
+	if len(c.jobTaskSpec.PreHooks) > 0 {
+		if err := c.runHookStage(ctx, c.jobTaskSpec.PreHooks, "pre-deploy"); err != nil {
+			return
+		}
+	}
+
 	if err := c.run(ctx); err != nil {
 		return
 	}
@@ -74,13 +95,35 @@ func (c *DeployJobCtl) Run(ctx context.Context) {
 		return
 	}
 	c.wait(ctx)
+
+	if (c.job.Status == config.StatusFailed || c.job.Status == config.StatusTimeout) && c.jobTaskSpec.RollbackOnFailure {
+		c.rollbackOnFailure(ctx)
+	}
+
+	c.finalizeBlueGreen(ctx)
+
+	if c.job.Status != config.StatusPassed || len(c.jobTaskSpec.PostHooks) == 0 {
+		return
+	}
+	c.runHookStage(ctx, c.jobTaskSpec.PostHooks, "post-deploy")
 }
 
-func (c *DeployJobCtl) run(ctx context.Context) error {
-	var (
-		err      error
-		replaced = false
-	)
+// runHookStage runs a set of pre/post deploy hooks and fails the job with a
+// message identifying which stage failed.
+func (c *DeployJobCtl) runHookStage(ctx context.Context, hooks []*commonmodels.DeployHook, stage string) error {
+	if err := runHooks(ctx, hooks, c.namespace, c.kubeClient, c.logger); err != nil {
+		msg := fmt.Sprintf("%s hook failed: %v", stage, err)
+		c.logger.Error(msg)
+		c.job.Status = config.StatusFailed
+		c.job.Error = msg
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// prepareClient resolves the target env's namespace and cluster and sets up
+// c.kubeClient/c.restConfig so both hook stages and run() can use them.
+func (c *DeployJobCtl) prepareClient() error {
 	env, err := commonrepo.NewProductColl().Find(&commonrepo.ProductFindOptions{
 		Name:    c.workflowCtx.ProjectName,
 		EnvName: c.jobTaskSpec.Env,
@@ -117,6 +160,42 @@ func (c *DeployJobCtl) run(ctx context.Context) error {
 		c.kubeClient = krkubeclient.Client()
 		c.restConfig = krkubeclient.RESTConfig()
 	}
+	return nil
+}
+
+func (c *DeployJobCtl) run(ctx context.Context) error {
+	if len(c.jobTaskSpec.ClusterIDs) > 0 {
+		if err := c.runMultiCluster(ctx); err != nil {
+			c.logger.Error(err)
+			c.job.Status = config.StatusFailed
+			c.job.Error = err.Error()
+			return err
+		}
+		c.job.Spec = c.jobTaskSpec
+		return nil
+	}
+
+	resources, err := c.replaceImage(ctx, c.namespace, c.kubeClient)
+	if err != nil {
+		msg := err.Error()
+		c.logger.Error(msg)
+		c.job.Status = config.StatusFailed
+		c.job.Error = msg
+		return err
+	}
+	c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, resources...)
+	c.job.Spec = c.jobTaskSpec
+	return nil
+}
+
+// replaceImage swaps the image of the target service's workload container in
+// the given cluster and returns the resources it touched. It is shared by
+// the single-cluster path and the multi-cluster fan-out in runMultiCluster.
+func (c *DeployJobCtl) replaceImage(ctx context.Context, namespace string, kubeClient crClient.Client) ([]commonmodels.Resource, error) {
+	var (
+		err       error
+		resources []commonmodels.Resource
+	)
 
 	// get servcie info
 	var (
@@ -139,11 +218,7 @@ func (c *DeployJobCtl) run(ctx context.Context) error {
 				Type:          c.jobTaskSpec.ServiceType,
 			})
 		if err != nil {
-			msg := fmt.Sprintf("find service %s error: %v", c.jobTaskSpec.ServiceName, err)
-			c.logger.Error(msg)
-			c.job.Status = config.StatusFailed
-			c.job.Error = msg
-			return errors.New(msg)
+			return nil, fmt.Errorf("find service %s error: %v", c.jobTaskSpec.ServiceName, err)
 		}
 	}
 
@@ -151,42 +226,32 @@ func (c *DeployJobCtl) run(ctx context.Context) error {
 		selector = labels.Set{setting.ProductLabel: c.workflowCtx.ProjectName, setting.ServiceLabel: c.jobTaskSpec.ServiceName}.AsSelector()
 
 		var deployments []*appsv1.Deployment
-		deployments, err = getter.ListDeployments(env.Namespace, selector, c.kubeClient)
+		deployments, err = getter.ListDeployments(namespace, selector, kubeClient)
 		if err != nil {
-			c.logger.Error(err)
-			c.job.Status = config.StatusFailed
-			c.job.Error = err.Error()
-			return err
+			return nil, err
 		}
 
 		var statefulSets []*appsv1.StatefulSet
-		statefulSets, err = getter.ListStatefulSets(env.Namespace, selector, c.kubeClient)
+		statefulSets, err = getter.ListStatefulSets(namespace, selector, kubeClient)
 		if err != nil {
-			c.logger.Error(err)
-			c.job.Status = config.StatusFailed
-			c.job.Error = err.Error()
-			return err
+			return nil, err
+		}
+
+		var daemonSets []*appsv1.DaemonSet
+		daemonSets, err = getter.ListDaemonSets(namespace, selector, kubeClient)
+		if err != nil {
+			return nil, err
 		}
 
 	L:
 		for _, deploy := range deployments {
 			for _, container := range deploy.Spec.Template.Spec.Containers {
 				if container.Name == c.jobTaskSpec.ServiceModule {
-					err = updater.UpdateDeploymentImage(deploy.Namespace, deploy.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, c.kubeClient)
+					deployResources, err := c.updateDeployment(ctx, namespace, kubeClient, deploy, container)
 					if err != nil {
-						msg := fmt.Sprintf("failed to update container image in %s/deployments/%s/%s: %v", env.Namespace, deploy.Name, container.Name, err)
-						c.logger.Error(msg)
-						c.job.Status = config.StatusFailed
-						c.job.Error = msg
-						return errors.New(msg)
+						return nil, err
 					}
-					c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, commonmodels.Resource{
-						Kind:      setting.Deployment,
-						Container: container.Name,
-						Origin:    container.Image,
-						Name:      deploy.Name,
-					})
-					replaced = true
+					resources = append(resources, deployResources...)
 					break L
 				}
 			}
@@ -195,97 +260,118 @@ func (c *DeployJobCtl) run(ctx context.Context) error {
 		for _, sts := range statefulSets {
 			for _, container := range sts.Spec.Template.Spec.Containers {
 				if container.Name == c.jobTaskSpec.ServiceModule {
-					err = updater.UpdateStatefulSetImage(sts.Namespace, sts.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, c.kubeClient)
+					err = updater.UpdateStatefulSetImage(sts.Namespace, sts.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, kubeClient)
 					if err != nil {
-						msg := fmt.Sprintf("failed to update container image in %s/statefulsets/%s/%s: %v", env.Namespace, sts.Name, container.Name, err)
-						c.logger.Error(msg)
-						c.job.Status = config.StatusFailed
-						c.job.Error = msg
-						return errors.New(msg)
+						return nil, fmt.Errorf("failed to update container image in %s/statefulsets/%s/%s: %v", namespace, sts.Name, container.Name, err)
 					}
-					c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, commonmodels.Resource{
+					resources = append(resources, commonmodels.Resource{
 						Kind:      setting.StatefulSet,
 						Container: container.Name,
 						Origin:    container.Image,
 						Name:      sts.Name,
 					})
-					replaced = true
 					break Loop
 				}
 			}
 		}
+	DS:
+		for _, ds := range daemonSets {
+			for _, container := range ds.Spec.Template.Spec.Containers {
+				if container.Name == c.jobTaskSpec.ServiceModule {
+					err = updater.UpdateDaemonSetImage(ds.Namespace, ds.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, kubeClient)
+					if err != nil {
+						return nil, fmt.Errorf("failed to update container image in %s/daemonsets/%s/%s: %v", namespace, ds.Name, container.Name, err)
+					}
+					resources = append(resources, commonmodels.Resource{
+						Kind:      setting.DaemonSet,
+						Container: container.Name,
+						Origin:    container.Image,
+						Name:      ds.Name,
+					})
+					break DS
+				}
+			}
+		}
 	} else {
 		switch serviceInfo.WorkloadType {
+		case setting.DaemonSet:
+			var daemonSet *appsv1.DaemonSet
+			daemonSet, _, err = getter.GetDaemonSet(namespace, c.jobTaskSpec.ServiceName, kubeClient)
+			if err != nil {
+				return nil, err
+			}
+			for _, container := range daemonSet.Spec.Template.Spec.Containers {
+				if container.Name == c.jobTaskSpec.ServiceModule {
+					err = updater.UpdateDaemonSetImage(daemonSet.Namespace, daemonSet.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, kubeClient)
+					if err != nil {
+						return nil, fmt.Errorf("failed to update container image in %s/daemonsets/%s/%s: %v", namespace, daemonSet.Name, container.Name, err)
+					}
+					resources = append(resources, commonmodels.Resource{
+						Kind:      setting.DaemonSet,
+						Container: container.Name,
+						Origin:    container.Image,
+						Name:      daemonSet.Name,
+					})
+				}
+			}
 		case setting.StatefulSet:
 			var statefulSet *appsv1.StatefulSet
-			statefulSet, _, err = getter.GetStatefulSet(env.Namespace, c.jobTaskSpec.ServiceName, c.kubeClient)
+			statefulSet, _, err = getter.GetStatefulSet(namespace, c.jobTaskSpec.ServiceName, kubeClient)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			for _, container := range statefulSet.Spec.Template.Spec.Containers {
 				if container.Name == c.jobTaskSpec.ServiceModule {
-					err = updater.UpdateStatefulSetImage(statefulSet.Namespace, statefulSet.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, c.kubeClient)
+					err = updater.UpdateStatefulSetImage(statefulSet.Namespace, statefulSet.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, kubeClient)
 					if err != nil {
-						msg := fmt.Sprintf("failed to update container image in %s/statefulsets/%s/%s: %v", env.Namespace, statefulSet.Name, container.Name, err)
-						c.logger.Error(msg)
-						c.job.Status = config.StatusFailed
-						c.job.Error = msg
-						return errors.New(msg)
+						return nil, fmt.Errorf("failed to update container image in %s/statefulsets/%s/%s: %v", namespace, statefulSet.Name, container.Name, err)
 					}
-					c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, commonmodels.Resource{
+					resources = append(resources, commonmodels.Resource{
 						Kind:      setting.StatefulSet,
 						Container: container.Name,
 						Origin:    container.Image,
 						Name:      statefulSet.Name,
 					})
-					replaced = true
-					break
 				}
 			}
 		case setting.Deployment:
 			var deployment *appsv1.Deployment
-			deployment, _, err = getter.GetDeployment(env.Namespace, c.jobTaskSpec.ServiceName, c.kubeClient)
+			deployment, _, err = getter.GetDeployment(namespace, c.jobTaskSpec.ServiceName, kubeClient)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			for _, container := range deployment.Spec.Template.Spec.Containers {
 				if container.Name == c.jobTaskSpec.ServiceModule {
-					err = updater.UpdateDeploymentImage(deployment.Namespace, deployment.Name, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Image, c.kubeClient)
+					deployResources, err := c.updateDeployment(ctx, namespace, kubeClient, deployment, container)
 					if err != nil {
-						msg := fmt.Sprintf("failed to update container image in %s/deployments/%s/%s: %v", env.Namespace, deployment.Name, container.Name, err)
-						c.logger.Error(msg)
-						c.job.Status = config.StatusFailed
-						c.job.Error = msg
-						return errors.New(msg)
+						return nil, err
 					}
-					c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, commonmodels.Resource{
-						Kind:      setting.Deployment,
-						Container: container.Name,
-						Origin:    container.Image,
-						Name:      deployment.Name,
-					})
-					replaced = true
-					break
+					resources = append(resources, deployResources...)
 				}
 			}
 		}
 	}
-	if !replaced {
-		msg := fmt.Sprintf("service %s container name %s is not found in env %s", c.jobTaskSpec.ServiceName, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Env)
-		c.logger.Error(msg)
-		c.job.Status = config.StatusFailed
-		c.job.Error = msg
-		return errors.New(msg)
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("service %s container name %s is not found in env %s", c.jobTaskSpec.ServiceName, c.jobTaskSpec.ServiceModule, c.jobTaskSpec.Env)
 	}
-	c.job.Spec = c.jobTaskSpec
-	return nil
+	return resources, nil
 }
 
 func (c *DeployJobCtl) wait(ctx context.Context) {
+	if len(c.jobTaskSpec.ClusterIDs) > 0 {
+		c.waitMultiCluster(ctx)
+		return
+	}
+
 	timeout := time.After(time.Duration(c.timeout()) * time.Second)
 
 	selector := labels.Set{setting.ProductLabel: c.workflowCtx.ProjectName, setting.ServiceLabel: c.jobTaskSpec.ServiceName}.AsSelector()
 
+	watcher := newResourceWatcher(ctx, c.restConfig, c.namespace, c.jobTaskSpec.ReplaceResources, c.logger)
+	backoff := newJitterBackoff(500*time.Millisecond, 15*time.Second, 2, true)
+	checker := NewReadyChecker(c.namespace, c.kubeClient, c.jobTaskSpec.CRDReadyJSONPath, c.logger)
+	wasReady := false
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -325,56 +411,26 @@ func (c *DeployJobCtl) wait(ctx context.Context) {
 			c.job.Status = config.StatusTimeout
 			return
 
-		default:
-			time.Sleep(time.Second * 2)
+		case <-watcher.changed:
+			backoff.Reset()
+
+		case <-time.After(backoff.Next()):
 			ready := true
-			var err error
-		L:
 			for _, resource := range c.jobTaskSpec.ReplaceResources {
-				switch resource.Kind {
-				case setting.Deployment:
-					d, found, e := getter.GetDeployment(c.namespace, resource.Name, c.kubeClient)
-					if e != nil {
-						err = e
-					}
-					if e != nil || !found {
-						c.logger.Errorf(
-							"failed to check deployment ready status %s/%s/%s - %v",
-							c.namespace,
-							resource.Kind,
-							resource.Name,
-							e,
-						)
-						ready = false
-					} else {
-						ready = wrapper.Deployment(d).Ready()
-					}
-
-					if !ready {
-						break L
-					}
-				case setting.StatefulSet:
-					st, found, e := getter.GetStatefulSet(c.namespace, resource.Name, c.kubeClient)
-					if e != nil {
-						err = e
-					}
-					if err != nil || !found {
-						c.logger.Errorf(
-							"failed to check statefulSet ready status %s/%s/%s",
-							c.namespace,
-							resource.Kind,
-							resource.Name,
-							e,
-						)
-						ready = false
-					} else {
-						ready = wrapper.StatefulSet(st).Ready()
-					}
-
-					if !ready {
-						break L
-					}
+				r, err := checker.IsReady(resource)
+				if err != nil {
+					c.logger.Errorf("failed to check ready status of %s %s/%s: %v", resource.Kind, c.namespace, resource.Name, err)
 				}
+				if !r {
+					ready = false
+					break
+				}
+			}
+
+			if ready != wasReady {
+				wasReady = ready
+				backoff.Reset()
+				c.ack()
 			}
 
 			if ready {
@@ -0,0 +1,245 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/rest"
+	crClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/koderover/zadig/pkg/microservice/aslan/config"
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+	kubeclient "github.com/koderover/zadig/pkg/shared/kube/client"
+	"github.com/koderover/zadig/pkg/tool/kube/updater"
+)
+
+// Propagation strategies for JobTaskDeploySpec.PropagationStrategy, modelled
+// after Karmada's propagation policies.
+const (
+	PropagationDuplicated = "Duplicated"
+	PropagationWeighted   = "Weighted"
+	PropagationAggregated = "Aggregated"
+)
+
+// runMultiCluster resolves every target cluster's REST config, performs the
+// image swap, and records per-cluster results so wait() can aggregate
+// readiness across the whole fan-out. The propagation strategy controls how
+// the rollout is spread across clusters:
+//   - Duplicated (default): every cluster gets the full update, in parallel.
+//   - Weighted: every cluster gets the full update, in parallel, and is then
+//     scaled to its share of JobTaskDeploySpec.TotalReplicas per ClusterWeights.
+//   - Aggregated: clusters are updated sequentially, each taking an even
+//     share of the remaining replica budget, so the total never exceeds
+//     TotalReplicas.
+func (c *DeployJobCtl) runMultiCluster(ctx context.Context) error {
+	c.clusterClients = make(map[string]crClient.Client)
+	c.clusterRestConfigs = make(map[string]*rest.Config)
+
+	switch c.jobTaskSpec.PropagationStrategy {
+	case PropagationAggregated:
+		return c.runMultiClusterAggregated(ctx)
+	default:
+		return c.runMultiClusterParallel(ctx)
+	}
+}
+
+// runMultiClusterParallel handles both Duplicated and Weighted propagation:
+// every cluster receives the image update concurrently, and for Weighted it
+// is additionally scaled to its share of TotalReplicas once updated.
+func (c *DeployJobCtl) runMultiClusterParallel(ctx context.Context) error {
+	g, _ := errgroup.WithContext(ctx)
+	var mu sync.Mutex
+	for _, clusterID := range c.jobTaskSpec.ClusterIDs {
+		clusterID := clusterID
+		g.Go(func() error {
+			kubeClient, restConfig, err := c.clusterClient(clusterID)
+			if err != nil {
+				return err
+			}
+
+			resources, err := c.replaceImage(ctx, c.namespace, kubeClient)
+			if err != nil {
+				return fmt.Errorf("cluster %s: %v", clusterID, err)
+			}
+			for i := range resources {
+				resources[i].ClusterID = clusterID
+			}
+
+			if c.jobTaskSpec.PropagationStrategy == PropagationWeighted {
+				if err := c.scaleClusterWeighted(clusterID, kubeClient, resources); err != nil {
+					return fmt.Errorf("cluster %s: %v", clusterID, err)
+				}
+			}
+
+			mu.Lock()
+			c.clusterClients[clusterID] = kubeClient
+			c.clusterRestConfigs[clusterID] = restConfig
+			c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, resources...)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// runMultiClusterAggregated updates clusters one at a time, each taking an
+// even share of whatever replica budget is left, so the sum of replicas
+// across every cluster never exceeds TotalReplicas. Once the budget is
+// exhausted, remaining clusters in ClusterIDs are left untouched entirely
+// (no image swap, no client recorded) rather than receiving the new image
+// with their replica count merely left unscaled.
+func (c *DeployJobCtl) runMultiClusterAggregated(ctx context.Context) error {
+	remaining := c.jobTaskSpec.TotalReplicas
+	clusterIDs := c.jobTaskSpec.ClusterIDs
+
+	for i, clusterID := range clusterIDs {
+		if remaining <= 0 {
+			c.logger.Infof("aggregated propagation: replica budget exhausted, leaving cluster %s and %d remaining cluster(s) untouched", clusterID, len(clusterIDs)-i-1)
+			break
+		}
+
+		kubeClient, restConfig, err := c.clusterClient(clusterID)
+		if err != nil {
+			return err
+		}
+
+		resources, err := c.replaceImage(ctx, c.namespace, kubeClient)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %v", clusterID, err)
+		}
+		for j := range resources {
+			resources[j].ClusterID = clusterID
+		}
+
+		share := int32(math.Ceil(float64(remaining) / float64(len(clusterIDs)-i)))
+		if share > remaining {
+			share = remaining
+		}
+		if err := c.scaleClusterResources(clusterID, kubeClient, resources, share); err != nil {
+			return fmt.Errorf("cluster %s: %v", clusterID, err)
+		}
+		remaining -= share
+
+		c.clusterClients[clusterID] = kubeClient
+		c.clusterRestConfigs[clusterID] = restConfig
+		c.jobTaskSpec.ReplaceResources = append(c.jobTaskSpec.ReplaceResources, resources...)
+	}
+
+	return nil
+}
+
+func (c *DeployJobCtl) clusterClient(clusterID string) (crClient.Client, *rest.Config, error) {
+	restConfig, err := kubeclient.GetRESTConfig(config.HubServerAddress(), clusterID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cluster %s: can't get k8s rest config: %v", clusterID, err)
+	}
+	kubeClient, err := kubeclient.GetKubeClient(config.HubServerAddress(), clusterID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cluster %s: can't init k8s client: %v", clusterID, err)
+	}
+	return kubeClient, restConfig, nil
+}
+
+func (c *DeployJobCtl) scaleClusterWeighted(clusterID string, kubeClient crClient.Client, resources []commonmodels.Resource) error {
+	totalWeight := int32(0)
+	for _, w := range c.jobTaskSpec.ClusterWeights {
+		totalWeight += w
+	}
+	if totalWeight <= 0 || c.jobTaskSpec.TotalReplicas <= 0 {
+		return nil
+	}
+	weight := c.jobTaskSpec.ClusterWeights[clusterID]
+	share := int32(math.Ceil(float64(c.jobTaskSpec.TotalReplicas) * float64(weight) / float64(totalWeight)))
+	return c.scaleClusterResources(clusterID, kubeClient, resources, share)
+}
+
+// scaleClusterResources scales resources to replicas. A weight of 0 is a
+// deliberate "drain this cluster" signal (e.g. Weighted propagation scaling
+// a cluster down for removal), so replicas is not floored to 1 here.
+func (c *DeployJobCtl) scaleClusterResources(clusterID string, kubeClient crClient.Client, resources []commonmodels.Resource, replicas int32) error {
+	if replicas < 0 {
+		replicas = 0
+	}
+	for _, resource := range resources {
+		switch resource.Kind {
+		case setting.Deployment:
+			if err := updater.UpdateDeploymentReplicas(c.namespace, resource.Name, replicas, kubeClient); err != nil {
+				return fmt.Errorf("failed to scale deployment %s/%s to %d replicas: %v", c.namespace, resource.Name, replicas, err)
+			}
+		case setting.StatefulSet:
+			if err := updater.UpdateStatefulSetReplicas(c.namespace, resource.Name, replicas, kubeClient); err != nil {
+				return fmt.Errorf("failed to scale statefulset %s/%s to %d replicas: %v", c.namespace, resource.Name, replicas, err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitMultiCluster aggregates readiness across every cluster targeted by the
+// fan-out and only passes once every cluster's workload is ready.
+func (c *DeployJobCtl) waitMultiCluster(ctx context.Context) {
+	timeout := time.After(time.Duration(c.timeout()) * time.Second)
+	backoff := newJitterBackoff(500*time.Millisecond, 15*time.Second, 2, true)
+
+	var lastIssue string
+	for {
+		select {
+		case <-ctx.Done():
+			c.job.Status = config.StatusCancelled
+			return
+		case <-timeout:
+			c.job.Status = config.StatusTimeout
+			c.job.Error = fmt.Sprintf("timed out waiting for multi-cluster deploy to become ready: %s", lastIssue)
+			return
+		case <-time.After(backoff.Next()):
+			allReady := true
+			for _, resource := range c.jobTaskSpec.ReplaceResources {
+				kubeClient, ok := c.clusterClients[resource.ClusterID]
+				if !ok {
+					lastIssue = fmt.Sprintf("cluster %s: no client available for %s/%s", resource.ClusterID, resource.Kind, resource.Name)
+					allReady = false
+					continue
+				}
+				checker := NewReadyChecker(c.namespace, kubeClient, c.jobTaskSpec.CRDReadyJSONPath, c.logger)
+				ready, err := checker.IsReady(resource)
+				if err != nil {
+					lastIssue = fmt.Sprintf("cluster %s: failed to check ready status of %s/%s: %v", resource.ClusterID, resource.Kind, resource.Name, err)
+					c.logger.Errorf(lastIssue)
+					allReady = false
+					continue
+				}
+				if !ready {
+					lastIssue = fmt.Sprintf("cluster %s: %s/%s is not ready yet", resource.ClusterID, resource.Kind, resource.Name)
+					allReady = false
+				}
+			}
+
+			if allReady {
+				c.job.Status = config.StatusPassed
+				return
+			}
+		}
+	}
+}
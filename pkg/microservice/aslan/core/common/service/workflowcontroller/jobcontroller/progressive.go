@@ -0,0 +1,311 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	crClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/koderover/zadig/pkg/microservice/aslan/config"
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+	"github.com/koderover/zadig/pkg/shared/kube/wrapper"
+	"github.com/koderover/zadig/pkg/tool/kube/getter"
+	"github.com/koderover/zadig/pkg/tool/kube/updater"
+)
+
+// Deploy strategies for JobTaskDeploySpec.DeployStrategy. RollingUpdate is
+// the long-standing default behavior; Canary and BlueGreen are progressive
+// delivery modes layered on top of the same Deployment resource.
+const (
+	DeployStrategyRollingUpdate = "RollingUpdate"
+	DeployStrategyCanary        = "Canary"
+	DeployStrategyBlueGreen     = "BlueGreen"
+)
+
+// rolloutLabel tags the pod template of a canary/green Deployment so the
+// corresponding Service selector can be flipped between revisions.
+const rolloutLabel = "zadig-rollout-track"
+
+const defaultCanaryWeight = 20
+
+// blueGreenCleanup is a retired "blue" Deployment awaiting deletion once the
+// job's final status (including any rollback) is known.
+type blueGreenCleanup struct {
+	kubeClient crClient.Client
+	namespace  string
+	deploy     *appsv1.Deployment
+	cooldown   time.Duration
+}
+
+// updateDeployment applies the image change to deploy according to the
+// configured DeployStrategy, returning the resource(s) that end up part of
+// the steady-state deploy. For Canary this is the primary Deployment (the
+// transient canary is not included); for BlueGreen it is the new green
+// Deployment.
+func (c *DeployJobCtl) updateDeployment(ctx context.Context, namespace string, kubeClient crClient.Client, deploy *appsv1.Deployment, container corev1.Container) ([]commonmodels.Resource, error) {
+	switch c.jobTaskSpec.DeployStrategy {
+	case DeployStrategyCanary:
+		return c.runCanary(ctx, namespace, kubeClient, deploy, container)
+	case DeployStrategyBlueGreen:
+		return c.runBlueGreen(ctx, namespace, kubeClient, deploy, container)
+	default:
+		if err := updater.UpdateDeploymentImage(deploy.Namespace, deploy.Name, container.Name, c.jobTaskSpec.Image, kubeClient); err != nil {
+			return nil, fmt.Errorf("failed to update container image in %s/deployments/%s/%s: %v", namespace, deploy.Name, container.Name, err)
+		}
+		return []commonmodels.Resource{{
+			Kind:      setting.Deployment,
+			Container: container.Name,
+			Origin:    container.Image,
+			Name:      deploy.Name,
+		}}, nil
+	}
+}
+
+// runCanary clones deploy as "<name>-canary" with a fraction of its replicas
+// running the new image, waits for it to become ready, optionally pauses
+// for an analysis window, then promotes the primary and removes the canary.
+// The canary is transient: once it is promoted and deleted it is not part
+// of the returned resources, so wait() never tracks a Deployment that no
+// longer exists. If anything after creation fails — readiness, analysis,
+// promotion, or the job's ctx being cancelled — the canary is deleted
+// before returning so it never survives as an orphan.
+func (c *DeployJobCtl) runCanary(ctx context.Context, namespace string, kubeClient crClient.Client, deploy *appsv1.Deployment, container corev1.Container) (resources []commonmodels.Resource, err error) {
+	canaryName := deploy.Name + "-canary"
+
+	weight := c.jobTaskSpec.CanaryWeight
+	if weight <= 0 || weight > 100 {
+		weight = defaultCanaryWeight
+	}
+	replicas := int32(math.Ceil(float64(*deploy.Spec.Replicas) * float64(weight) / 100))
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	canary := deploy.DeepCopy()
+	canary.Name = canaryName
+	canary.ResourceVersion = ""
+	canary.UID = ""
+	canary.Spec.Replicas = &replicas
+	canary.Labels = mergeLabel(canary.Labels, rolloutLabel, "canary")
+	canary.Spec.Template.Labels = mergeLabel(canary.Spec.Template.Labels, rolloutLabel, "canary")
+	for i, cc := range canary.Spec.Template.Spec.Containers {
+		if cc.Name == container.Name {
+			canary.Spec.Template.Spec.Containers[i].Image = c.jobTaskSpec.Image
+		}
+	}
+
+	if err = kubeClient.Create(ctx, canary); err != nil {
+		return nil, fmt.Errorf("failed to create canary deployment %s/%s: %v", namespace, canaryName, err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx may already be cancelled/timed out at this point, so clean up
+		// with a fresh context rather than leaving the canary orphaned.
+		if delErr := kubeClient.Delete(context.Background(), canary); delErr != nil && !apierrors.IsNotFound(delErr) {
+			c.logger.Errorf("failed to clean up orphaned canary deployment %s/%s: %v", namespace, canaryName, delErr)
+		}
+	}()
+
+	if err = c.waitDeploymentReady(ctx, namespace, kubeClient, canaryName); err != nil {
+		return nil, fmt.Errorf("canary deployment %s/%s never became ready: %v", namespace, canaryName, err)
+	}
+
+	if c.jobTaskSpec.CanaryAnalysis != nil {
+		if err = c.runAnalysis(ctx, c.jobTaskSpec.CanaryAnalysis); err != nil {
+			return nil, fmt.Errorf("canary analysis failed: %v", err)
+		}
+	}
+
+	if err = updater.UpdateDeploymentImage(deploy.Namespace, deploy.Name, container.Name, c.jobTaskSpec.Image, kubeClient); err != nil {
+		return nil, fmt.Errorf("failed to promote canary to primary deployment %s/%s: %v", namespace, deploy.Name, err)
+	}
+	if delErr := kubeClient.Delete(ctx, canary); delErr != nil && !apierrors.IsNotFound(delErr) {
+		c.logger.Errorf("failed to delete canary deployment %s/%s: %v", namespace, canaryName, delErr)
+	}
+
+	return []commonmodels.Resource{{Kind: setting.Deployment, Container: container.Name, Origin: container.Image, Name: deploy.Name}}, nil
+}
+
+// runBlueGreen creates a parallel "<name>-green" Deployment running the new
+// image, waits for it to become ready, then flips the Service selector from
+// blue to green. The retired "blue" Deployment is handed to
+// finalizeBlueGreen rather than deleted here, so it survives until the
+// job's final status (including any rollback) is known. A rollback restores
+// the previous image on the green Deployment; the Service selector stays on
+// green. If anything after creation
+// fails — readiness, the service flip, or the job's ctx being cancelled —
+// the green Deployment is deleted before returning so it never survives as
+// an orphan.
+func (c *DeployJobCtl) runBlueGreen(ctx context.Context, namespace string, kubeClient crClient.Client, deploy *appsv1.Deployment, container corev1.Container) (resources []commonmodels.Resource, err error) {
+	greenName := deploy.Name + "-green"
+
+	green := deploy.DeepCopy()
+	green.Name = greenName
+	green.ResourceVersion = ""
+	green.UID = ""
+	green.Labels = mergeLabel(green.Labels, rolloutLabel, "green")
+	green.Spec.Template.Labels = mergeLabel(green.Spec.Template.Labels, rolloutLabel, "green")
+	for i, cc := range green.Spec.Template.Spec.Containers {
+		if cc.Name == container.Name {
+			green.Spec.Template.Spec.Containers[i].Image = c.jobTaskSpec.Image
+		}
+	}
+
+	if err = kubeClient.Create(ctx, green); err != nil {
+		return nil, fmt.Errorf("failed to create green deployment %s/%s: %v", namespace, greenName, err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx may already be cancelled/timed out at this point, so clean up
+		// with a fresh context rather than leaving the green deployment orphaned.
+		if delErr := kubeClient.Delete(context.Background(), green); delErr != nil && !apierrors.IsNotFound(delErr) {
+			c.logger.Errorf("failed to clean up orphaned green deployment %s/%s: %v", namespace, greenName, delErr)
+		}
+	}()
+
+	if err = c.waitDeploymentReady(ctx, namespace, kubeClient, greenName); err != nil {
+		return nil, fmt.Errorf("green deployment %s/%s never became ready: %v", namespace, greenName, err)
+	}
+
+	svc, found, svcErr := getter.GetService(namespace, c.jobTaskSpec.ServiceName, kubeClient)
+	if svcErr != nil || !found {
+		err = fmt.Errorf("failed to find service %s/%s to flip blue/green selector: %v", namespace, c.jobTaskSpec.ServiceName, svcErr)
+		return nil, err
+	}
+	svc.Spec.Selector = mergeLabel(svc.Spec.Selector, rolloutLabel, "green")
+	if err = kubeClient.Update(ctx, svc); err != nil {
+		return nil, fmt.Errorf("failed to flip service %s/%s selector to green: %v", namespace, svc.Name, err)
+	}
+
+	cooldown := time.Duration(c.jobTaskSpec.BlueGreenCooldownSeconds) * time.Second
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	c.blueGreenCleanups = append(c.blueGreenCleanups, &blueGreenCleanup{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		deploy:     deploy,
+		cooldown:   cooldown,
+	})
+
+	return []commonmodels.Resource{{Kind: setting.Deployment, Container: container.Name, Origin: container.Image, Name: greenName}}, nil
+}
+
+// finalizeBlueGreen deletes the retired "blue" Deployments recorded by
+// runBlueGreen, once the job's final status (including any rollback
+// triggered by a failed readiness check) is known. Each is deleted after
+// its cooldown only if the job passed; for any other status, whether or not
+// a rollback ran, the blue Deployments are left in place.
+func (c *DeployJobCtl) finalizeBlueGreen(ctx context.Context) {
+	if len(c.blueGreenCleanups) == 0 {
+		return
+	}
+	if c.job.Status != config.StatusPassed {
+		c.logger.Infof("deploy job did not pass, leaving %d blue/green deployment(s) in place", len(c.blueGreenCleanups))
+		return
+	}
+
+	for _, cleanup := range c.blueGreenCleanups {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cleanup.cooldown):
+		}
+		if err := cleanup.kubeClient.Delete(ctx, cleanup.deploy); err != nil {
+			c.logger.Errorf("failed to delete old blue deployment %s/%s after cooldown: %v", cleanup.namespace, cleanup.deploy.Name, err)
+		}
+	}
+}
+
+func (c *DeployJobCtl) waitDeploymentReady(ctx context.Context, namespace string, kubeClient crClient.Client, name string) error {
+	timeout := time.After(time.Duration(c.timeout()) * time.Second)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled")
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for deployment to become ready")
+		case <-ticker.C:
+			d, found, err := getter.GetDeployment(namespace, name, kubeClient)
+			if err != nil || !found {
+				continue
+			}
+			if wrapper.Deployment(d).Ready() {
+				return nil
+			}
+		}
+	}
+}
+
+// runAnalysis pauses the rollout for the analysis window. With a ProbeURL
+// set, the probe is polled during the window: a 5xx response fails the
+// analysis at once, while successful responses and request errors do not end
+// the pause. The analysis passes when the window elapses.
+func (c *DeployJobCtl) runAnalysis(ctx context.Context, analysis *commonmodels.CanaryAnalysis) error {
+	if analysis.ProbeURL == "" {
+		time.Sleep(time.Duration(analysis.WindowSeconds) * time.Second)
+		return nil
+	}
+
+	deadline := time.After(time.Duration(analysis.WindowSeconds) * time.Second)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled")
+		case <-deadline:
+			return nil
+		case <-ticker.C:
+			resp, err := http.Get(analysis.ProbeURL)
+			if err != nil {
+				c.logger.Warnf("canary analysis probe %s failed: %v", analysis.ProbeURL, err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				return fmt.Errorf("analysis probe %s returned %d", analysis.ProbeURL, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func mergeLabel(labels map[string]string, key, value string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/jsonpath"
+	crClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+	"github.com/koderover/zadig/pkg/shared/kube/wrapper"
+	"github.com/koderover/zadig/pkg/tool/kube/getter"
+)
+
+// defaultCRDReadyJSONPath is used to decide readiness of a custom resource
+// when JobTaskDeploySpec.CRDReadyJSONPath is left empty.
+const defaultCRDReadyJSONPath = `{.status.conditions[?(@.type=="Ready")].status}`
+
+// ReadyChecker knows how to decide whether a kube resource tracked by a
+// deploy job has reached its desired state. It mirrors the readiness rules
+// Helm applies in kube/ready.go so that a deploy job is not limited to
+// Deployment/StatefulSet workloads. Deployment, StatefulSet and DaemonSet
+// are reachable today via replaceImage; Job is reachable via hook.go's
+// waitHookComplete. Pod, PersistentVolumeClaim, Service and the generic
+// CRD path are not produced by any caller, but are kept here
+// as the extension point the next workload/hook kind should be wired
+// through rather than reimplementing readiness logic elsewhere.
+type ReadyChecker struct {
+	namespace  string
+	kubeClient crClient.Client
+	logger     *zap.SugaredLogger
+	// crdJSONPath overrides the default JSONPath expression used to decide
+	// readiness of generic custom resources.
+	crdJSONPath string
+}
+
+func NewReadyChecker(namespace string, kubeClient crClient.Client, crdJSONPath string, logger *zap.SugaredLogger) *ReadyChecker {
+	if crdJSONPath == "" {
+		crdJSONPath = defaultCRDReadyJSONPath
+	}
+	return &ReadyChecker{
+		namespace:   namespace,
+		kubeClient:  kubeClient,
+		logger:      logger,
+		crdJSONPath: crdJSONPath,
+	}
+}
+
+// IsReady reports whether the resource referenced by resource.Kind/Name has
+// reached its desired state. A false result with a nil error means the
+// resource is still progressing; a non-nil error means the resource could
+// not be fetched or evaluated at all.
+func (r *ReadyChecker) IsReady(resource commonmodels.Resource) (bool, error) {
+	switch resource.Kind {
+	case setting.Deployment:
+		d, found, err := getter.GetDeployment(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get deployment %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return wrapper.Deployment(d).Ready(), nil
+	case setting.StatefulSet:
+		st, found, err := getter.GetStatefulSet(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get statefulset %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return r.statefulSetReady(st), nil
+	case setting.DaemonSet:
+		ds, found, err := getter.GetDaemonSet(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get daemonset %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return ds.Status.ObservedGeneration >= ds.Generation &&
+			ds.Status.NumberReady == ds.Status.DesiredNumberScheduled, nil
+	case setting.Job:
+		job, found, err := getter.GetJob(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get job %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return r.jobComplete(job), nil
+	case setting.PersistentVolumeClaim:
+		pvc, found, err := getter.GetPVC(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get pvc %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return pvc.Status.Phase == corev1.ClaimBound, nil
+	case setting.Service:
+		svc, found, err := getter.GetService(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get service %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return r.serviceReady(svc), nil
+	case setting.Pod:
+		pod, found, err := getter.GetPod(r.namespace, resource.Name, r.kubeClient)
+		if err != nil || !found {
+			return false, fmt.Errorf("failed to get pod %s/%s: %v", r.namespace, resource.Name, err)
+		}
+		return wrapper.Pod(pod).Resource().Status == setting.StatusRunning, nil
+	default:
+		return r.customResourceReady(resource)
+	}
+}
+
+func (r *ReadyChecker) statefulSetReady(st *appsv1.StatefulSet) bool {
+	if st.Status.ObservedGeneration < st.Generation {
+		return false
+	}
+	if st.Status.UpdateRevision != st.Status.CurrentRevision {
+		if st.Spec.UpdateStrategy.RollingUpdate != nil && st.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+			partition := *st.Spec.UpdateStrategy.RollingUpdate.Partition
+			if st.Status.UpdatedReplicas < *st.Spec.Replicas-partition {
+				return false
+			}
+		} else {
+			return false
+		}
+	}
+	return st.Status.ReadyReplicas == *st.Spec.Replicas
+}
+
+func (r *ReadyChecker) jobComplete(job *batchv1.Job) bool {
+	if job.Status.Succeeded < 1 {
+		return false
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobComplete && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ReadyChecker) serviceReady(svc *corev1.Service) bool {
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		return len(svc.Status.LoadBalancer.Ingress) > 0
+	}
+	return svc.Spec.ClusterIP != ""
+}
+
+// customResourceReady evaluates the configured JSONPath expression against
+// the raw object and treats a resolved value of "True" as ready.
+func (r *ReadyChecker) customResourceReady(resource commonmodels.Resource) (bool, error) {
+	obj, found, err := getter.GetUnstructured(r.namespace, resource.Name, resource.GroupVersionKind(), r.kubeClient)
+	if err != nil || !found {
+		return false, fmt.Errorf("failed to get %s %s/%s: %v", resource.Kind, r.namespace, resource.Name, err)
+	}
+
+	jp := jsonpath.New(resource.Name)
+	if err := jp.Parse(r.crdJSONPath); err != nil {
+		return false, fmt.Errorf("invalid readiness jsonpath %q: %v", r.crdJSONPath, err)
+	}
+	results, err := jp.FindResults(obj.Object)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		return false, nil
+	}
+	return fmt.Sprintf("%v", results[0][0].Interface()) == "True", nil
+}
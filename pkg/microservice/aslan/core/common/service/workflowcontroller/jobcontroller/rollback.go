@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/koderover/zadig/pkg/microservice/aslan/config"
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+	"github.com/koderover/zadig/pkg/tool/kube/updater"
+)
+
+// RollbackStatus values recorded per resource so the UI can distinguish a
+// deploy that recovered via rollback from one where rollback also failed.
+const (
+	RollbackStatusSucceeded = "succeeded"
+	RollbackStatusFailed    = "failed"
+)
+
+// rollbackOnFailure restores every replaced resource's image to the value
+// recorded in Resource.Origin and waits (bounded by 2x the deploy timeout)
+// for the workloads to become ready again. It mutates c.jobTaskSpec in
+// place so RollbackStatus is persisted alongside ReplaceResources.
+func (c *DeployJobCtl) rollbackOnFailure(ctx context.Context) {
+	deadline := time.After(2 * time.Duration(c.timeout()) * time.Second)
+
+	anyFailed := false
+	for i := len(c.jobTaskSpec.ReplaceResources) - 1; i >= 0; i-- {
+		resource := &c.jobTaskSpec.ReplaceResources[i]
+
+		kubeClient := c.kubeClient
+		if resource.ClusterID != "" {
+			clusterClient, ok := c.clusterClients[resource.ClusterID]
+			if !ok {
+				resource.RollbackStatus = RollbackStatusFailed
+				anyFailed = true
+				c.logger.Errorf("rollback: no client for cluster %s, can't restore %s %s/%s", resource.ClusterID, resource.Kind, c.namespace, resource.Name)
+				continue
+			}
+			kubeClient = clusterClient
+		}
+
+		var err error
+		switch resource.Kind {
+		case setting.Deployment:
+			err = updater.UpdateDeploymentImage(c.namespace, resource.Name, resource.Container, resource.Origin, kubeClient)
+		case setting.StatefulSet:
+			err = updater.UpdateStatefulSetImage(c.namespace, resource.Name, resource.Container, resource.Origin, kubeClient)
+		case setting.DaemonSet:
+			err = updater.UpdateDaemonSetImage(c.namespace, resource.Name, resource.Container, resource.Origin, kubeClient)
+		default:
+			continue
+		}
+		if err != nil {
+			resource.RollbackStatus = RollbackStatusFailed
+			anyFailed = true
+			c.logger.Errorf("rollback: failed to restore %s %s/%s to %s: %v", resource.Kind, c.namespace, resource.Name, resource.Origin, err)
+			continue
+		}
+
+		checker := NewReadyChecker(c.namespace, kubeClient, c.jobTaskSpec.CRDReadyJSONPath, c.logger)
+		if err := c.waitRollbackReady(ctx, checker, *resource, deadline); err != nil {
+			resource.RollbackStatus = RollbackStatusFailed
+			anyFailed = true
+			c.logger.Errorf("rollback: %s %s/%s did not become ready: %v", resource.Kind, c.namespace, resource.Name, err)
+			continue
+		}
+		resource.RollbackStatus = RollbackStatusSucceeded
+	}
+
+	if anyFailed {
+		c.job.Status = config.StatusFailed
+		c.job.Error = fmt.Sprintf("rollback failed: %s", c.job.Error)
+		return
+	}
+	c.job.Error = fmt.Sprintf("deploy failed, rollback succeeded: %s", c.job.Error)
+}
+
+func (c *DeployJobCtl) waitRollbackReady(ctx context.Context, checker *ReadyChecker, resource commonmodels.Resource, deadline <-chan time.Time) error {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled")
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for rollback readiness")
+		case <-ticker.C:
+			ready, err := checker.IsReady(resource)
+			if err != nil {
+				continue
+			}
+			if ready {
+				return nil
+			}
+		}
+	}
+}
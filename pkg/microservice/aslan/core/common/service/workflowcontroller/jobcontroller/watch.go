@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The KodeRover Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jobcontroller
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	commonmodels "github.com/koderover/zadig/pkg/microservice/aslan/core/common/repository/models"
+	"github.com/koderover/zadig/pkg/setting"
+)
+
+// resourceWatcher drives a "changed" signal for the resources a deploy job
+// is tracking so wait() can reset its poll backoff when something happens,
+// re-checking readiness after the shortest interval instead of the grown
+// one. When a watch cannot be established
+// (e.g. RBAC on a hub-agent cluster forbids it) changed stays unused and
+// callers fall back to the jittered backoff poll.
+type resourceWatcher struct {
+	changed chan struct{}
+}
+
+// newResourceWatcher starts one watch per Deployment/StatefulSet resource in
+// resources. It is best-effort: resources it cannot watch (unsupported kind,
+// or a Watch call rejected by RBAC) are silently left to the fallback poll.
+func newResourceWatcher(ctx context.Context, restConfig *rest.Config, namespace string, resources []commonmodels.Resource, logger *zap.SugaredLogger) *resourceWatcher {
+	rw := &resourceWatcher{changed: make(chan struct{}, 1)}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logger.Warnf("can't build clientset for watch, falling back to polling: %v", err)
+		return rw
+	}
+
+	notify := func() {
+		select {
+		case rw.changed <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, resource := range resources {
+		listOpts := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", resource.Name).String()}
+		switch resource.Kind {
+		case setting.Deployment:
+			watcher, err := clientset.AppsV1().Deployments(namespace).Watch(ctx, listOpts)
+			if err != nil {
+				logger.Warnf("can't watch deployment %s/%s, falling back to polling: %v", namespace, resource.Name, err)
+				continue
+			}
+			go forwardWatchEvents(ctx, watcher, notify)
+		case setting.StatefulSet:
+			watcher, err := clientset.AppsV1().StatefulSets(namespace).Watch(ctx, listOpts)
+			if err != nil {
+				logger.Warnf("can't watch statefulset %s/%s, falling back to polling: %v", namespace, resource.Name, err)
+				continue
+			}
+			go forwardWatchEvents(ctx, watcher, notify)
+		}
+	}
+
+	return rw
+}
+
+func forwardWatchEvents(ctx context.Context, watcher watch.Interface, notify func()) {
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			notify()
+		}
+	}
+}